@@ -0,0 +1,275 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// exploreEveryNIntervals controls how often the adaptive tailer tries
+// to grow maxInflight again after it was reduced because of an
+// overload: every Nth sampling interval, alpha is doubled (capped at
+// 1.0) unless the interval itself was overloaded.
+const exploreEveryNIntervals = 10
+
+// AdaptiveConcurrencyMetric is implemented by the Prometheus metric
+// that AdaptiveTailerWithMetrics reports its limiter state to, once
+// per sampling interval.
+type AdaptiveConcurrencyMetric interface {
+	Register()
+	Observe(maxInflight float64, noLoadLatencySeconds float64, maxQPS float64)
+	Unregister()
+}
+
+// AdaptiveTailer is a Tailer whose consumer must call Done() once for
+// every line read from Lines(), as soon as that line has been fully
+// processed. Done() closes the latency sample that started when the
+// line was admitted, which is what the limiter uses to size itself.
+type AdaptiveTailer interface {
+	Tailer
+	Done()
+}
+
+type adaptiveSample struct {
+	latency  time.Duration
+	inflight int
+}
+
+// adaptiveTailer throttles how many lines are in flight between orig
+// and the consumer based on measured processing latency, following
+// the auto-concurrency algorithm used by brpc: maxInflight is derived
+// from the no-load latency (the latency floor observed when the
+// consumer isn't backed up) and the peak observed throughput, scaled
+// by alpha. alpha is halved when the system looks overloaded and
+// grown again during periodic explore phases, so the limit tracks
+// changes in the consumer's capacity over time.
+type adaptiveTailer struct {
+	orig   Tailer
+	lines  chan string
+	errors chan Error
+	done   chan struct{}
+
+	mu            sync.Mutex
+	cond          *sync.Cond
+	inflight      int
+	maxInflight   int
+	pending       []time.Time
+	samples       []adaptiveSample
+	tickCount     int
+	alpha         float64
+	noLoadLatency time.Duration
+	maxQPS        float64
+}
+
+func (t *adaptiveTailer) Lines() chan string {
+	return t.lines
+}
+
+func (t *adaptiveTailer) Errors() chan Error {
+	return t.errors
+}
+
+func (t *adaptiveTailer) Close() {
+	t.mu.Lock()
+	close(t.done)
+	t.mu.Unlock()
+	t.cond.Broadcast()
+}
+
+// AdaptiveTailerWithMetrics wraps orig with the concurrency limiter
+// described above. initialMaxInflight is used until the first
+// sampling interval has produced enough data to size the limit.
+func AdaptiveTailerWithMetrics(orig Tailer, metric AdaptiveConcurrencyMetric, initialMaxInflight int) AdaptiveTailer {
+	metric.Register()
+	if initialMaxInflight < 1 {
+		initialMaxInflight = 1
+	}
+	t := &adaptiveTailer{
+		orig:        orig,
+		lines:       make(chan string),
+		errors:      make(chan Error),
+		done:        make(chan struct{}),
+		maxInflight: initialMaxInflight,
+		alpha:       0.9,
+	}
+	t.cond = sync.NewCond(&t.mu)
+	go t.produce(metric)
+	go t.sample(metric)
+	return t
+}
+
+// produce also runs metric.Unregister() here, right before the
+// channels are closed: sample() and produce() are independent
+// goroutines, so only closing t.done gives no guarantee about which
+// of the two finishes first, and a consumer that ranges over Lines()
+// until it's closed needs Unregister() to have already happened by
+// then (see boundedBufferedTailer.consume() for the same pattern).
+func (t *adaptiveTailer) produce(metric AdaptiveConcurrencyMetric) {
+	defer close(t.errors)
+	defer close(t.lines)
+	defer metric.Unregister()
+	defer t.orig.Close()
+	for {
+		select {
+		case line, ok := <-t.orig.Lines():
+			if !ok {
+				return
+			}
+			if !t.acquire() {
+				return
+			}
+			t.mu.Lock()
+			t.pending = append(t.pending, time.Now())
+			t.mu.Unlock()
+			select {
+			case t.lines <- line:
+			case <-t.done:
+				return
+			}
+		case err, ok := <-t.orig.Errors():
+			if !ok {
+				continue
+			}
+			select {
+			case t.errors <- err:
+			case <-t.done:
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// acquire blocks until fewer than maxInflight lines are currently
+// admitted, then reserves a slot. It returns false if the tailer was
+// closed while waiting.
+func (t *adaptiveTailer) acquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.inflight >= t.maxInflight {
+		select {
+		case <-t.done:
+			return false
+		default:
+		}
+		t.cond.Wait()
+	}
+	t.inflight++
+	return true
+}
+
+// Done records the processing latency for the oldest still-pending
+// line and frees up its inflight slot. Consumers are expected to call
+// Done() in the same order they received lines from Lines().
+func (t *adaptiveTailer) Done() {
+	t.mu.Lock()
+	if len(t.pending) > 0 {
+		start := t.pending[0]
+		t.pending = t.pending[1:]
+		t.samples = append(t.samples, adaptiveSample{
+			latency:  time.Since(start),
+			inflight: t.inflight,
+		})
+	}
+	if t.inflight > 0 {
+		t.inflight--
+	}
+	t.mu.Unlock()
+	t.cond.Broadcast()
+}
+
+// sample only observes the limiter state; metric.Unregister() is
+// called from produce() instead, so that it is synchronized with the
+// channel close that signals Close() has completed (see produce()).
+func (t *adaptiveTailer) sample(metric AdaptiveConcurrencyMetric) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.tick(metric)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// tick evaluates the samples collected since the last tick, updates
+// noLoadLatency, maxQPS and alpha, and derives a new maxInflight from
+// them.
+func (t *adaptiveTailer) tick(metric AdaptiveConcurrencyMetric) {
+	t.mu.Lock()
+	samples := t.samples
+	t.samples = nil
+	t.tickCount++
+
+	var (
+		sum           time.Duration
+		noLoadLatency time.Duration
+		haveNoLoad    bool
+	)
+	for _, s := range samples {
+		sum += s.latency
+		if s.inflight <= 2 && (!haveNoLoad || s.latency < noLoadLatency) {
+			noLoadLatency = s.latency
+			haveNoLoad = true
+		}
+	}
+
+	qps := float64(len(samples))
+	if qps > t.maxQPS {
+		t.maxQPS = qps
+	}
+
+	if haveNoLoad {
+		if t.noLoadLatency == 0 {
+			t.noLoadLatency = noLoadLatency
+		} else {
+			// Exponentially smoothed minimum: each interval's floor
+			// moves the estimate 10% of the way towards it, up or
+			// down, so a single unusually fast or slow interval
+			// doesn't yank the floor.
+			t.noLoadLatency = time.Duration(float64(t.noLoadLatency)*0.9 + float64(noLoadLatency)*0.1)
+		}
+	}
+
+	overloaded := false
+	if len(samples) > 0 && t.noLoadLatency > 0 {
+		avgLatency := sum / time.Duration(len(samples))
+		overloaded = avgLatency > 2*t.noLoadLatency
+	}
+	if overloaded {
+		t.alpha = t.alpha / 2
+	} else if t.tickCount%exploreEveryNIntervals == 0 {
+		t.alpha = math.Min(1.0, t.alpha*2)
+	}
+
+	if t.noLoadLatency > 0 && t.maxQPS > 0 {
+		limit := int(math.Ceil(t.maxQPS * t.noLoadLatency.Seconds() * t.alpha))
+		if limit < 1 {
+			limit = 1
+		}
+		t.maxInflight = limit
+	}
+
+	maxInflight, noLoadLatencySeconds, maxQPS := float64(t.maxInflight), t.noLoadLatency.Seconds(), t.maxQPS
+	t.mu.Unlock()
+	t.cond.Broadcast()
+
+	metric.Observe(maxInflight, noLoadLatencySeconds, maxQPS)
+}
@@ -0,0 +1,210 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import (
+	"sync"
+	"time"
+)
+
+// BoundedLineBufferMetric is implemented by the Prometheus metric used
+// with BufferedTailerWithLimits. In addition to the peak-lines gauge
+// inherited from LineBufferMetric, it exposes the peak number of bytes
+// buffered, so operators can see how close the buffer is to
+// maxBufferedBytes before the producer starts blocking.
+type BoundedLineBufferMetric interface {
+	LineBufferMetric
+	ObserveBufferedBytes(currentBytes float64)
+}
+
+// boundedBufferedTailer is like bufferedTailer, but the queue between
+// orig and the consumer is bounded: once maxLines or maxBufferedBytes
+// is reached, the goroutine reading from orig blocks until the
+// consumer has made room. The first line is always admitted even if
+// it alone exceeds maxBufferedBytes, so a single oversized line cannot
+// deadlock the tailer.
+type boundedBufferedTailer struct {
+	orig   Tailer
+	lines  chan string
+	errors chan Error
+	done   chan struct{}
+
+	maxLines int
+	maxBytes int
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	queue       []string
+	queuedBytes int
+	closed      bool
+
+	peakLines float64
+	peakBytes float64
+}
+
+func (t *boundedBufferedTailer) Lines() chan string {
+	return t.lines
+}
+
+func (t *boundedBufferedTailer) Errors() chan Error {
+	return t.errors
+}
+
+func (t *boundedBufferedTailer) Close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.cond.Broadcast()
+	close(t.done)
+}
+
+// BufferedTailerWithLimits wraps orig with a bounded in-memory queue.
+// Unlike BufferedTailerWithMetrics, it does not grow without limit:
+// once maxLines lines or maxBufferedBytes bytes are queued, the
+// goroutine feeding from orig.Lines() blocks until the consumer reads
+// from Lines(), applying backpressure instead of risking an OOM during
+// ingest spikes.
+func BufferedTailerWithLimits(orig Tailer, metric BoundedLineBufferMetric, maxLines, maxBufferedBytes int) Tailer {
+	metric.Register()
+	t := &boundedBufferedTailer{
+		orig:     orig,
+		lines:    make(chan string),
+		errors:   make(chan Error),
+		done:     make(chan struct{}),
+		maxLines: maxLines,
+		maxBytes: maxBufferedBytes,
+	}
+	t.cond = sync.NewCond(&t.mu)
+	go t.produce()
+	go t.consume(metric)
+	go t.reportMetrics(metric)
+	return t
+}
+
+// produce reads from orig and appends to the queue, blocking in admit()
+// while the queue is full.
+func (t *boundedBufferedTailer) produce() {
+	defer t.orig.Close()
+	for {
+		select {
+		case line, ok := <-t.orig.Lines():
+			if !ok {
+				t.mu.Lock()
+				t.closed = true
+				t.mu.Unlock()
+				t.cond.Broadcast()
+				return
+			}
+			t.admit(line)
+		case err, ok := <-t.orig.Errors():
+			if !ok {
+				continue
+			}
+			select {
+			case t.errors <- err:
+			case <-t.done:
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// admit blocks until line fits within the configured limits, then adds
+// it to the queue. A line is only counted against maxLines/maxBytes
+// once it is actually admitted: we never speculatively reserve space
+// and roll back. produce() is the only caller, so lines are admitted
+// strictly in arrival order: a big line that is waiting for room holds
+// back the lines behind it and is never overtaken by them. The queue
+// is never left empty while a line is waiting, so a single line larger
+// than maxBufferedBytes is still admitted and does not block forever.
+func (t *boundedBufferedTailer) admit(line string) {
+	size := len(line)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for !t.closed && len(t.queue) > 0 && (len(t.queue)+1 > t.maxLines || t.queuedBytes+size > t.maxBytes) {
+		t.cond.Wait()
+	}
+	if t.closed {
+		return
+	}
+	t.queue = append(t.queue, line)
+	t.queuedBytes += size
+	if l := float64(len(t.queue)); l > t.peakLines {
+		t.peakLines = l
+	}
+	if b := float64(t.queuedBytes); b > t.peakBytes {
+		t.peakBytes = b
+	}
+	t.cond.Broadcast()
+}
+
+// consume pops lines off the queue and forwards them on t.lines,
+// broadcasting on every successful pop so that producers blocked in
+// admit() re-check whether their line now fits. metric.Unregister()
+// runs here too, before the channels are closed: that gives Close() a
+// single well-defined completion point, so a caller that ranges over
+// Lines() until it is closed is guaranteed Unregister() has already
+// happened by the time it observes that.
+func (t *boundedBufferedTailer) consume(metric BoundedLineBufferMetric) {
+	defer close(t.errors)
+	defer close(t.lines)
+	defer metric.Unregister()
+	for {
+		t.mu.Lock()
+		for len(t.queue) == 0 && !t.closed {
+			t.cond.Wait()
+		}
+		if len(t.queue) == 0 && t.closed {
+			t.mu.Unlock()
+			return
+		}
+		line := t.queue[0]
+		t.mu.Unlock()
+
+		select {
+		case t.lines <- line:
+			t.mu.Lock()
+			t.queue = t.queue[1:]
+			t.queuedBytes -= len(line)
+			t.mu.Unlock()
+			t.cond.Broadcast()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// reportMetrics only observes the peak gauges; metric.Unregister() is
+// called from consume() instead, so that it is synchronized with the
+// channel close that signals Close() has completed (see consume()).
+func (t *boundedBufferedTailer) reportMetrics(metric BoundedLineBufferMetric) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			peakLines, peakBytes := t.peakLines, t.peakBytes
+			t.peakLines, t.peakBytes = float64(len(t.queue)), float64(t.queuedBytes)
+			t.mu.Unlock()
+			metric.Observe(peakLines)
+			metric.ObserveBufferedBytes(peakBytes)
+		case <-t.done:
+			return
+		}
+	}
+}
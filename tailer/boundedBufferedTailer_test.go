@@ -0,0 +1,122 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type peakBytesMetric struct {
+	peakLoadMetric
+	peakBytes float64
+}
+
+func (m *peakBytesMetric) ObserveBufferedBytes(currentBytes float64) {
+	if currentBytes > m.peakBytes {
+		m.peakBytes = currentBytes
+	}
+}
+
+func TestBoundedBufferSequential(t *testing.T) {
+	src := &sourceTailer{lines: make(chan string)}
+	metric := &peakBytesMetric{}
+	buffered := BufferedTailerWithLimits(src, metric, 10000, 10*1024*1024)
+	for i := 1; i <= 10000; i++ {
+		src.lines <- fmt.Sprintf("This is line number %v.", i)
+	}
+	for i := 1; i <= 10000; i++ {
+		line := <-buffered.Lines()
+		if line != fmt.Sprintf("This is line number %v.", i) {
+			t.Errorf("Expected 'This is line number %v', but got '%v'.", i, line)
+		}
+	}
+	time.Sleep(1100 * time.Millisecond)
+	buffered.Close()
+	_, stillOpen := <-buffered.Lines()
+	if stillOpen {
+		t.Error("Bounded buffered tailer was not closed.")
+	}
+	_, stillOpen = <-src.Lines()
+	if stillOpen {
+		t.Error("Source tailer was not closed.")
+	}
+	if !metric.registerCalled || !metric.unregisterCalled {
+		t.Error("metric.Register()/Unregister() not called.")
+	}
+}
+
+// A producer that keeps sending once the queue is full must block
+// until the consumer makes room, instead of the line being dropped or
+// the buffer growing past maxLines.
+func TestBoundedBufferBlocksWhenFull(t *testing.T) {
+	src := &sourceTailer{lines: make(chan string)}
+	metric := &peakBytesMetric{}
+	buffered := BufferedTailerWithLimits(src, metric, 2, 1024)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i <= 5; i++ {
+			src.lines <- fmt.Sprintf("line-%v", i)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Error("producer did not block once maxLines was reached")
+	case <-time.After(200 * time.Millisecond):
+		// expected: producer is blocked because the consumer has not read yet.
+	}
+
+	for i := 1; i <= 5; i++ {
+		line := <-buffered.Lines()
+		if line != fmt.Sprintf("line-%v", i) {
+			t.Errorf("Expected 'line-%v', but got '%v'.", i, line)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Error("producer never finished after consumer drained the queue")
+	}
+	buffered.Close()
+}
+
+// A single line larger than maxBufferedBytes must still be admitted,
+// rather than blocking forever.
+func TestBoundedBufferAdmitsOversizedLine(t *testing.T) {
+	src := &sourceTailer{lines: make(chan string)}
+	metric := &peakBytesMetric{}
+	buffered := BufferedTailerWithLimits(src, metric, 10, 8)
+
+	big := strings.Repeat("x", 100)
+	go func() {
+		src.lines <- big
+	}()
+
+	select {
+	case line := <-buffered.Lines():
+		if line != big {
+			t.Errorf("Expected the oversized line to be delivered unchanged, got '%v'.", line)
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("oversized line was never admitted")
+	}
+	buffered.Close()
+}
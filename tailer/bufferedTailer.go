@@ -0,0 +1,116 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import (
+	"time"
+)
+
+// LineBufferMetric is implemented by the Prometheus metric that
+// BufferedTailerWithMetrics uses to report how full the buffer is.
+// Observe() is called once per second with the peak number of lines
+// that were queued up during that second.
+type LineBufferMetric interface {
+	Register()
+	Observe(currentLoad float64)
+	Unregister()
+}
+
+type bufferedTailer struct {
+	lines  chan string
+	errors chan Error
+	done   chan struct{}
+}
+
+func (t *bufferedTailer) Lines() chan string {
+	return t.lines
+}
+
+func (t *bufferedTailer) Errors() chan Error {
+	return t.errors
+}
+
+func (t *bufferedTailer) Close() {
+	close(t.done)
+}
+
+// BufferedTailerWithMetrics wraps orig with an unbounded in-memory
+// queue, so that a slow consumer reading from Lines() does not block
+// orig from tailing the log source. metric is notified once per
+// second with the peak number of buffered lines, so operators can see
+// how far the consumer is falling behind.
+func BufferedTailerWithMetrics(orig Tailer, metric LineBufferMetric) Tailer {
+	metric.Register()
+	t := &bufferedTailer{
+		lines:  make(chan string),
+		errors: make(chan Error),
+		done:   make(chan struct{}),
+	}
+	go t.loop(orig, metric)
+	return t
+}
+
+func (t *bufferedTailer) loop(orig Tailer, metric LineBufferMetric) {
+	var (
+		queue  []string
+		out    chan string
+		next   string
+		peak   float64
+		ticker = time.NewTicker(1 * time.Second)
+	)
+	// Defers run LIFO, so metric.Unregister() runs first, before the
+	// channels are closed: that guarantees Unregister() has already
+	// happened by the time a caller observes Lines() closed (see
+	// boundedBufferedTailer.consume() for the same pattern).
+	defer ticker.Stop()
+	defer orig.Close()
+	defer close(t.errors)
+	defer close(t.lines)
+	defer metric.Unregister()
+	for {
+		if len(queue) > 0 {
+			out = t.lines
+			next = queue[0]
+		} else {
+			out = nil
+		}
+		select {
+		case line, ok := <-orig.Lines():
+			if !ok {
+				return
+			}
+			queue = append(queue, line)
+			if float64(len(queue)) > peak {
+				peak = float64(len(queue))
+			}
+		case err, ok := <-orig.Errors():
+			if !ok {
+				continue
+			}
+			select {
+			case t.errors <- err:
+			case <-t.done:
+				return
+			}
+		case out <- next:
+			queue = queue[1:]
+		case <-ticker.C:
+			metric.Observe(peak)
+			peak = float64(len(queue))
+		case <-t.done:
+			return
+		}
+	}
+}
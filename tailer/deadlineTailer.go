@@ -0,0 +1,109 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDeadlineExceeded is returned by DeadlineTailer.LinesWithDeadline
+// when no line arrived within the given timeout.
+var ErrDeadlineExceeded = errors.New("timed out waiting for a line")
+
+// StalledSourceMetric is implemented by the Prometheus metric that
+// DeadlineTailer reports to whenever a deadline set up by
+// LinesWithDeadline fires. consecutiveStalls is the number of
+// deadlines that have fired in a row since the last line arrived, so
+// operators can distinguish an occasional slow line from a source
+// that is genuinely stuck.
+type StalledSourceMetric interface {
+	Register()
+	Observe(consecutiveStalls float64)
+	Unregister()
+}
+
+// DeadlineTailer wraps a Tailer with LinesWithDeadline, which lets the
+// caller tell a quiet log source apart from one that is stuck: Lines()
+// itself still blocks indefinitely, but LinesWithDeadline returns
+// ErrDeadlineExceeded if timeout elapses first.
+type DeadlineTailer interface {
+	Tailer
+	LinesWithDeadline(timeout time.Duration) (string, error)
+}
+
+type deadlineTailer struct {
+	orig Tailer
+
+	metric      StalledSourceMetric
+	timer       *time.Timer
+	consecutive float64
+}
+
+// NewDeadlineTailer wraps orig with LinesWithDeadline. metric is
+// notified with the number of consecutive deadline timeouts every
+// time a deadline fires, and reset to zero as soon as a line arrives
+// again.
+func NewDeadlineTailer(orig Tailer, metric StalledSourceMetric) DeadlineTailer {
+	metric.Register()
+	return &deadlineTailer{
+		orig:   orig,
+		metric: metric,
+		timer:  time.NewTimer(0),
+	}
+}
+
+func (t *deadlineTailer) Lines() chan string {
+	return t.orig.Lines()
+}
+
+func (t *deadlineTailer) Errors() chan Error {
+	return t.orig.Errors()
+}
+
+func (t *deadlineTailer) Close() {
+	t.timer.Stop()
+	t.metric.Unregister()
+	t.orig.Close()
+}
+
+// LinesWithDeadline waits for the next line from orig, returning
+// ErrDeadlineExceeded if none arrives within timeout. On each timeout
+// it reports the running count of consecutive timeouts to metric; the
+// count is reset as soon as a line is delivered.
+func (t *deadlineTailer) LinesWithDeadline(timeout time.Duration) (string, error) {
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	t.timer.Reset(timeout)
+	select {
+	case line, ok := <-t.orig.Lines():
+		if !ok {
+			return "", errors.New("tailer closed")
+		}
+		if t.consecutive > 0 {
+			t.consecutive = 0
+			t.metric.Observe(0)
+		}
+		return line, nil
+	case <-t.timer.C:
+		t.consecutive++
+		t.metric.Observe(t.consecutive)
+		return "", ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import (
+	"testing"
+	"time"
+)
+
+type stalledSourceMetric struct {
+	registerCalled, unregisterCalled bool
+	lastConsecutive                  float64
+}
+
+func (m *stalledSourceMetric) Register() {
+	m.registerCalled = true
+}
+
+func (m *stalledSourceMetric) Observe(consecutiveStalls float64) {
+	m.lastConsecutive = consecutiveStalls
+}
+
+func (m *stalledSourceMetric) Unregister() {
+	m.unregisterCalled = true
+}
+
+// When a line arrives before the deadline, LinesWithDeadline must
+// return it without error and without touching the metric.
+func TestDeadlineTailerReturnsLine(t *testing.T) {
+	src := &sourceTailer{lines: make(chan string)}
+	metric := &stalledSourceMetric{}
+	deadline := NewDeadlineTailer(src, metric)
+
+	go func() {
+		src.lines <- "hello"
+	}()
+
+	line, err := deadline.LinesWithDeadline(1 * time.Second)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v.", err)
+	}
+	if line != "hello" {
+		t.Errorf("Expected 'hello', but got '%v'.", line)
+	}
+	if metric.lastConsecutive != 0 {
+		t.Errorf("Expected no stall to be observed, but got %v.", metric.lastConsecutive)
+	}
+	deadline.Close()
+}
+
+// When no line arrives before the deadline, LinesWithDeadline must
+// return ErrDeadlineExceeded and report the growing number of
+// consecutive stalls, resetting to zero once a line finally arrives.
+func TestDeadlineTailerReportsRepeatedStalls(t *testing.T) {
+	src := &sourceTailer{lines: make(chan string)}
+	metric := &stalledSourceMetric{}
+	deadline := NewDeadlineTailer(src, metric)
+
+	for i := 1; i <= 3; i++ {
+		_, err := deadline.LinesWithDeadline(10 * time.Millisecond)
+		if err != ErrDeadlineExceeded {
+			t.Fatalf("Expected ErrDeadlineExceeded, but got %v.", err)
+		}
+		if metric.lastConsecutive != float64(i) {
+			t.Errorf("Expected %v consecutive stalls, but got %v.", i, metric.lastConsecutive)
+		}
+	}
+
+	go func() {
+		src.lines <- "back again"
+	}()
+	line, err := deadline.LinesWithDeadline(1 * time.Second)
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v.", err)
+	}
+	if line != "back again" {
+		t.Errorf("Expected 'back again', but got '%v'.", line)
+	}
+	if metric.lastConsecutive != 0 {
+		t.Errorf("Expected consecutive stalls to be reset to 0, but got %v.", metric.lastConsecutive)
+	}
+
+	deadline.Close()
+	if !metric.registerCalled || !metric.unregisterCalled {
+		t.Error("metric.Register()/Unregister() not called.")
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+// Tailer reads lines from a log source (a file, a glob of files, stdin,
+// ...) and makes them available on Lines(). Errors that are not fatal
+// to the process, but that the caller should know about (e.g. a log
+// file was truncated), are reported on Errors().
+type Tailer interface {
+	Lines() chan string
+	Errors() chan Error
+	Close()
+}
+
+// Error is sent on a Tailer's Errors() channel when something went
+// wrong while reading from the underlying source. Cause is the
+// original error, Message gives additional context about what
+// grok_exporter was doing when Cause occurred.
+type Error struct {
+	Cause   error
+	Message string
+}
+
+func NewError(message string, cause error) Error {
+	return Error{
+		Cause:   cause,
+		Message: message,
+	}
+}
+
+func (e Error) Error() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Cause.Error()
+}
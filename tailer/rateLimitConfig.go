@@ -0,0 +1,59 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import "fmt"
+
+// RateLimitConfig is the per-input `rate_limit` section of the
+// grok_exporter configuration file, e.g.
+//
+//	rate_limit:
+//	    lines_per_second: 500
+//	    burst: 1000
+//	    policy: drop
+//	    sample_rate: 10
+//
+// sample_rate is only meaningful when policy is "sample".
+type RateLimitConfig struct {
+	LinesPerSecond float64 `yaml:"lines_per_second,omitempty"`
+	Burst          int     `yaml:"burst,omitempty"`
+	Policy         string  `yaml:"policy,omitempty"`
+	SampleRate     int     `yaml:"sample_rate,omitempty"`
+}
+
+// parsePolicy maps the Policy string to an OverflowPolicy, defaulting
+// to Block when Policy is empty.
+func (c RateLimitConfig) parsePolicy() (OverflowPolicy, error) {
+	switch c.Policy {
+	case "", "block":
+		return Block, nil
+	case "drop":
+		return Drop, nil
+	case "sample":
+		return Sample, nil
+	default:
+		return 0, fmt.Errorf("rate_limit: invalid policy %q, expected one of 'block', 'drop', 'sample'", c.Policy)
+	}
+}
+
+// NewRateLimitedTailerFromConfig builds a RateLimitedTailer from an
+// input's parsed `rate_limit:` section.
+func NewRateLimitedTailerFromConfig(orig Tailer, metric RateLimitMetric, cfg RateLimitConfig) (Tailer, error) {
+	policy, err := cfg.parsePolicy()
+	if err != nil {
+		return nil, err
+	}
+	return RateLimitedTailer(orig, metric, cfg.LinesPerSecond, cfg.Burst, policy, cfg.SampleRate), nil
+}
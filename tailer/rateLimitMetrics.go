@@ -0,0 +1,62 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// PrometheusRateLimitMetric is the production RateLimitMetric,
+// backed by a counter vector labeled by source and outcome
+// ("admitted" or "dropped") so operators can alert on a source that
+// stays over its configured rate_limit.
+type PrometheusRateLimitMetric struct {
+	sourceName string
+	counter    *prometheus.CounterVec
+}
+
+// NewPrometheusRateLimitMetric returns a RateLimitMetric for the
+// input named sourceName. counter is shared across all inputs that
+// configure rate_limit and must be labeled ["source", "outcome"], e.g.
+//
+//	prometheus.NewCounterVec(prometheus.CounterOpts{
+//	    Name: "grok_exporter_line_rate_limit_total",
+//	    Help: "Lines admitted or dropped by the per-source rate limiter.",
+//	}, []string{"source", "outcome"})
+func NewPrometheusRateLimitMetric(sourceName string, counter *prometheus.CounterVec) *PrometheusRateLimitMetric {
+	return &PrometheusRateLimitMetric{
+		sourceName: sourceName,
+		counter:    counter,
+	}
+}
+
+// Register pre-creates the admitted/dropped label combinations, so
+// they read zero from the start instead of being absent until the
+// first event.
+func (m *PrometheusRateLimitMetric) Register() {
+	m.counter.WithLabelValues(m.sourceName, "admitted")
+	m.counter.WithLabelValues(m.sourceName, "dropped")
+}
+
+func (m *PrometheusRateLimitMetric) IncAdmitted() {
+	m.counter.WithLabelValues(m.sourceName, "admitted").Inc()
+}
+
+func (m *PrometheusRateLimitMetric) IncDropped() {
+	m.counter.WithLabelValues(m.sourceName, "dropped").Inc()
+}
+
+func (m *PrometheusRateLimitMetric) Unregister() {
+	m.counter.DeleteLabelValues(m.sourceName, "admitted")
+	m.counter.DeleteLabelValues(m.sourceName, "dropped")
+}
@@ -0,0 +1,189 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// OverflowPolicy selects what RateLimitedTailer does with a line that
+// arrives while the source is over its configured rate limit.
+type OverflowPolicy int
+
+const (
+	// Block makes the producer goroutine wait for a token, so no line
+	// is ever lost but orig.Lines() may be read with a delay.
+	Block OverflowPolicy = iota
+	// Drop discards the line and increments the dropped-lines counter
+	// exposed through RateLimitMetric.
+	Drop
+	// Sample admits every Nth line while over the limit (N is the
+	// sampleRate passed to RateLimitedTailer) and drops the rest,
+	// counting both as it goes.
+	Sample
+)
+
+// RateLimitMetric is implemented by the Prometheus metric that
+// RateLimitedTailer reports admitted and dropped line counts to.
+type RateLimitMetric interface {
+	Register()
+	IncAdmitted()
+	IncDropped()
+	Unregister()
+}
+
+// rateLimitedTailer enforces a token-bucket rate limit of
+// linesPerSecond, with up to burst lines admitted in a single burst,
+// on orig's Lines() channel.
+type rateLimitedTailer struct {
+	orig   Tailer
+	lines  chan string
+	errors chan Error
+	done   chan struct{}
+
+	// ctx is cancelled alongside done, so limiter.Wait() in admit()
+	// never blocks past Close(). It is built once here rather than
+	// once per call, since a context.WithCancel that is only ever
+	// cancelled by done doesn't need its own goroutine per admitted
+	// line.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	limiter    *rate.Limiter
+	policy     OverflowPolicy
+	sampleRate int
+	seen       int
+}
+
+func (t *rateLimitedTailer) Lines() chan string {
+	return t.lines
+}
+
+func (t *rateLimitedTailer) Errors() chan Error {
+	return t.errors
+}
+
+func (t *rateLimitedTailer) Close() {
+	close(t.done)
+	t.cancel()
+}
+
+// RateLimitedTailer wraps orig so that lines are admitted on
+// Lines() at no more than linesPerSecond, with bursts of up to burst
+// lines. sampleRate is only used when policy is Sample: every
+// sampleRate-th line that arrives while the tailer is over its limit
+// is admitted, the rest are dropped. It is ignored for Block and
+// Drop.
+func RateLimitedTailer(orig Tailer, metric RateLimitMetric, linesPerSecond float64, burst int, policy OverflowPolicy, sampleRate int) Tailer {
+	metric.Register()
+	t := &rateLimitedTailer{
+		orig:       orig,
+		lines:      make(chan string),
+		errors:     make(chan Error),
+		done:       make(chan struct{}),
+		limiter:    rate.NewLimiter(rate.Limit(linesPerSecond), burst),
+		policy:     policy,
+		sampleRate: sampleRate,
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	go t.loop(metric)
+	return t
+}
+
+// loop forwards lines from orig to t.lines, applying the configured
+// overflow policy. Defers run LIFO, so metric.Unregister() runs first,
+// before the channels are closed: that guarantees Unregister() has
+// already happened by the time a caller observes Lines() closed (see
+// boundedBufferedTailer.consume() for the same pattern). t.cancel()
+// and orig.Close() run after, in whichever order shutdown needs them.
+func (t *rateLimitedTailer) loop(metric RateLimitMetric) {
+	origClosedItself := false
+	defer func() {
+		// orig already closed its own Lines()/Errors(); calling
+		// orig.Close() again would double-close its channels and
+		// panic for any tailer whose Close() is a plain close(ch),
+		// which is every tailer in this package.
+		if !origClosedItself {
+			t.orig.Close()
+		}
+	}()
+	defer t.cancel()
+	defer close(t.errors)
+	defer close(t.lines)
+	defer metric.Unregister()
+	for {
+		select {
+		case line, ok := <-t.orig.Lines():
+			if !ok {
+				origClosedItself = true
+				return
+			}
+			if !t.admit(line, metric) {
+				continue
+			}
+			select {
+			case t.lines <- line:
+			case <-t.done:
+				return
+			}
+		case err, ok := <-t.orig.Errors():
+			if !ok {
+				continue
+			}
+			select {
+			case t.errors <- err:
+			case <-t.done:
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// admit applies the configured overflow policy to line, returning
+// true if it should be forwarded on Lines(). It blocks the caller
+// when policy is Block and no token is currently available.
+func (t *rateLimitedTailer) admit(line string, metric RateLimitMetric) bool {
+	switch t.policy {
+	case Block:
+		if err := t.limiter.Wait(t.ctx); err != nil {
+			return false
+		}
+		metric.IncAdmitted()
+		return true
+	case Sample:
+		if t.limiter.Allow() {
+			metric.IncAdmitted()
+			return true
+		}
+		t.seen++
+		if t.sampleRate > 0 && t.seen%t.sampleRate == 0 {
+			metric.IncAdmitted()
+			return true
+		}
+		metric.IncDropped()
+		return false
+	default: // Drop
+		if t.limiter.Allow() {
+			metric.IncAdmitted()
+			return true
+		}
+		metric.IncDropped()
+		return false
+	}
+}
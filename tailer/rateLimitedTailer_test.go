@@ -0,0 +1,112 @@
+// Copyright 2016-2018 The grok_exporter Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tailer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type rateLimitMetric struct {
+	registerCalled, unregisterCalled bool
+	admitted, dropped                int
+}
+
+func (m *rateLimitMetric) Register() {
+	m.registerCalled = true
+}
+
+func (m *rateLimitMetric) IncAdmitted() {
+	m.admitted++
+}
+
+func (m *rateLimitMetric) IncDropped() {
+	m.dropped++
+}
+
+func (m *rateLimitMetric) Unregister() {
+	m.unregisterCalled = true
+}
+
+// With the Block policy, every line sent by the source must eventually
+// be delivered, none dropped.
+func TestRateLimitedTailerBlockDeliversAllLines(t *testing.T) {
+	src := &sourceTailer{lines: make(chan string)}
+	metric := &rateLimitMetric{}
+	limited := RateLimitedTailer(src, metric, 1000, 1000, Block, 0)
+
+	go func() {
+		for i := 1; i <= 50; i++ {
+			src.lines <- fmt.Sprintf("line-%v", i)
+		}
+	}()
+
+	for i := 1; i <= 50; i++ {
+		line := <-limited.Lines()
+		if line != fmt.Sprintf("line-%v", i) {
+			t.Errorf("Expected 'line-%v', but got '%v'.", i, line)
+		}
+	}
+	limited.Close()
+	_, stillOpen := <-limited.Lines()
+	if stillOpen {
+		t.Error("Rate limited tailer was not closed.")
+	}
+	if !metric.registerCalled || !metric.unregisterCalled {
+		t.Error("metric.Register()/Unregister() not called.")
+	}
+	if metric.admitted != 50 || metric.dropped != 0 {
+		t.Errorf("Expected 50 admitted and 0 dropped, got %v admitted and %v dropped.", metric.admitted, metric.dropped)
+	}
+}
+
+// With the Drop policy and a burst of 1, sending lines faster than the
+// limiter refills must result in some lines being dropped rather than
+// delivered or blocked on forever.
+func TestRateLimitedTailerDropsOverLimit(t *testing.T) {
+	src := &sourceTailer{lines: make(chan string)}
+	metric := &rateLimitMetric{}
+	limited := RateLimitedTailer(src, metric, 1, 1, Drop, 0)
+
+	go func() {
+		for i := 1; i <= 10; i++ {
+			src.lines <- fmt.Sprintf("line-%v", i)
+		}
+		src.Close()
+	}()
+
+	timeout := time.After(1 * time.Second)
+	received := 0
+loop:
+	for {
+		select {
+		case _, ok := <-limited.Lines():
+			if !ok {
+				break loop
+			}
+			received++
+		case <-timeout:
+			t.Fatal("rate limited tailer never closed")
+		}
+	}
+	limited.Close()
+	if received >= 10 {
+		t.Errorf("Expected some lines to be dropped, but all %v were delivered.", received)
+	}
+	if metric.dropped == 0 {
+		t.Error("Expected metric.IncDropped() to be called at least once.")
+	}
+}